@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/daaku/ensure"
+)
+
+func TestLoadExpectationsPreservesCommentsAndBlanks(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "expectations.txt")
+	const content = "# explains why a.js is flaky\na.js Flaky\n\nb.js Skip\n"
+	ensure.Nil(t, os.WriteFile(path, []byte(content), 0o644))
+
+	exp, err := loadExpectations(path)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, exp.entries, []expectation{
+		{raw: "# explains why a.js is flaky"},
+		{pattern: "a.js", status: statusFlaky},
+		{raw: ""},
+		{pattern: "b.js", status: statusSkip},
+	})
+}
+
+func TestLoadExpectationsInvalidLine(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "expectations.txt")
+	ensure.Nil(t, os.WriteFile(path, []byte("onefield\n"), 0o644))
+	_, err := loadExpectations(path)
+	ensure.NotNil(t, err)
+}
+
+func TestWriteExpectationsRoundTrip(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "expectations.txt")
+	entries := []expectation{
+		{raw: "# why a.js is flaky"},
+		{pattern: "a.js", status: statusFlaky},
+		{raw: ""},
+		{pattern: "b.js", status: statusSkip},
+	}
+	ensure.Nil(t, writeExpectations(path, entries))
+
+	exp, err := loadExpectations(path)
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, exp.entries, entries)
+}
+
+func TestReconcileUnexpectedFailure(t *testing.T) {
+	t.Parallel()
+	exp := &expectations{entries: []expectation{{pattern: "a.js", status: statusPass}}}
+	r := &runTestResult{path: "a.js", runEnd: qunitRunEnd{Status: "failed"}}
+	messages := reconcile(exp, r)
+	ensure.DeepEqual(t, messages, []string{"a.js: unexpected failure (expected Pass)"})
+}
+
+func TestReconcileExpectedFailure(t *testing.T) {
+	t.Parallel()
+	exp := &expectations{entries: []expectation{{pattern: "a.js", status: statusFailure}}}
+	r := &runTestResult{path: "a.js", runEnd: qunitRunEnd{Status: "failed"}}
+	ensure.DeepEqual(t, reconcile(exp, r), []string(nil))
+}
+
+func TestUpdateExpectationsPreservesSkipFlakySlowAndComments(t *testing.T) {
+	t.Parallel()
+	exp := &expectations{entries: []expectation{
+		{raw: "# c.js is flaky on CI"},
+		{pattern: "a.js", status: statusFailure},
+		{pattern: "c.js", status: statusFlaky},
+		{pattern: "d.js", status: statusSkip},
+	}}
+	results := []*runTestResult{
+		{path: "a.js", runEnd: qunitRunEnd{Status: "passed"}},
+		{path: "b.js", runEnd: qunitRunEnd{Status: "failed"}},
+	}
+	updated := updateExpectations(exp, results)
+	ensure.DeepEqual(t, updated, []expectation{
+		{raw: "# c.js is flaky on CI"},
+		{pattern: "a.js", status: statusPass},
+		{pattern: "b.js", status: statusFailure},
+		{pattern: "c.js", status: statusFlaky},
+		{pattern: "d.js", status: statusSkip},
+	})
+}
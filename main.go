@@ -3,9 +3,13 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	_ "embed"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"html/template"
 	"io"
@@ -14,12 +18,14 @@ import (
 	"mime"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path"
 	"path/filepath"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -27,11 +33,13 @@ import (
 	"time"
 
 	"github.com/bmatcuk/doublestar/v4"
+	"github.com/chromedp/cdproto/profiler"
 	cdruntime "github.com/chromedp/cdproto/runtime"
 	"github.com/chromedp/chromedp"
 	"github.com/davecgh/go-spew/spew"
 	esbapi "github.com/evanw/esbuild/pkg/api"
 	esbcli "github.com/evanw/esbuild/pkg/cli"
+	"github.com/fsnotify/fsnotify"
 	"github.com/jpillora/opts"
 	"github.com/kgadams/go-shellquote"
 	"github.com/pkg/errors"
@@ -68,20 +76,130 @@ func msSince(start time.Time) time.Duration {
 }
 
 type args struct {
-	Root        string        `opts:"help=root directory"`
-	Include     []string      `opts:"mode=arg,help=globs to include"`
-	Exclude     []string      `opts:"help=globs to exclude"`
-	ESBuild     string        `opts:"name=esbuild,help=esbuild arguments (as single string argument)"`
-	Coverage    bool          `opts:"help=enable code coverage"`
-	Timeout     time.Duration `opts:"help=timeout for all tests"`
-	Parallel    int           `opts:"help=number of parallel tests"`
-	Watch       bool          `opts:"help=watch mode"`
-	Visible     bool          `opts:"help=run visible browser"`
-	KeepRunning bool          `opts:"help=keep browser running after tests"`
-	Port        int           `opts:"help=use specific port for internal server"`
-}
-
-func testServer(ctx context.Context, args *args) (*http.Server, error) {
+	Root               string        `opts:"help=root directory"`
+	Include            []string      `opts:"mode=arg,help=globs to include"`
+	Exclude            []string      `opts:"help=globs to exclude"`
+	ESBuild            string        `opts:"name=esbuild,help=esbuild arguments (as single string argument)"`
+	Coverage           bool          `opts:"help=enable code coverage"`
+	Timeout            time.Duration `opts:"help=timeout for all tests"`
+	Parallel           int           `opts:"help=number of parallel tests"`
+	Watch              bool          `opts:"help=watch mode; re-runs tests affected by file changes and serves a live dashboard at /dashboard"`
+	Visible            bool          `opts:"help=run visible browser"`
+	KeepRunning        bool          `opts:"help=keep browser running after tests"`
+	Port               int           `opts:"help=use specific port for internal server"`
+	Remote             string        `opts:"help=connect to an already running Chrome instance at this CDP websocket URL instead of launching one"`
+	Host               string        `opts:"help=host the internal server advertises to the browser when it is remote"`
+	CoverageOut        string        `opts:"name=coverage-out,help=path to write the coverage report (.info/.lcov for LCOV else Istanbul JSON)"`
+	Expectations       string        `opts:"help=file mapping test globs (optionally >-joined QUnit test names) to Pass Failure Skip Flaky or Slow"`
+	UpdateExpectations bool          `opts:"name=update-expectations,help=rewrite the expectations file to match the observed run instead of checking it"`
+	Shard              string        `opts:"help=select shard N/M (1-based) of the discovered tests for distributed CI"`
+	ShardTimings       string        `opts:"name=shard-timings,help=JSON file of test durations to balance shards by; updated after each run"`
+	Reporter           string        `opts:"help=comma-separated reporters: pretty junit[:file] tap[:file] json[:file]; :file defaults to stdout except junit which defaults to junit.xml"`
+}
+
+// bundleCache remembers the bundled JS served for each /bundle/ request so
+// coverage reporting can recover the inline source map without rebuilding.
+type bundleCache struct {
+	mu sync.Mutex
+	m  map[string][]byte
+}
+
+func newBundleCache() *bundleCache {
+	return &bundleCache{m: make(map[string][]byte)}
+}
+
+func (c *bundleCache) store(path string, content []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.m[path] = content
+}
+
+func (c *bundleCache) load(path string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.m[path]
+	return b, ok
+}
+
+// depGraph records, for each test file, the absolute paths of the source
+// files its esbuild bundle pulled in (from the build's metafile), so a
+// changed file can be mapped back to the tests that transitively import it.
+type depGraph struct {
+	mu     sync.Mutex
+	byTest map[string]map[string]struct{}
+}
+
+func newDepGraph() *depGraph {
+	return &depGraph{byTest: make(map[string]map[string]struct{})}
+}
+
+func (g *depGraph) set(testPath string, sources []string) {
+	set := make(map[string]struct{}, len(sources))
+	for _, s := range sources {
+		set[s] = struct{}{}
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.byTest[testPath] = set
+}
+
+// affected returns, in sorted order, the test files whose last recorded
+// bundle depended on changed.
+func (g *depGraph) affected(changed string) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var tests []string
+	for test, sources := range g.byTest {
+		if _, ok := sources[changed]; ok {
+			tests = append(tests, test)
+		}
+	}
+	sort.Strings(tests)
+	return tests
+}
+
+// eventHub fans out JSON-encoded testEvents to the -watch dashboard's
+// /events subscribers.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[chan []byte]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[chan []byte]struct{})}
+}
+
+func (h *eventHub) subscribe() chan []byte {
+	ch := make(chan []byte, 16)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan []byte) {
+	h.mu.Lock()
+	delete(h.subs, ch)
+	h.mu.Unlock()
+}
+
+func (h *eventHub) broadcast(v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- b:
+		default: // subscriber is behind, drop rather than block the run
+		}
+	}
+}
+
+func testServer(ctx context.Context, args *args, cache *bundleCache, exp *expectations, dg *depGraph, hub *eventHub, reporters []Reporter, hostPtr *string) (*http.Server, error) {
 	esbuildArgs, err := shellquote.Split(args.ESBuild)
 	if err != nil {
 		return nil, errors.WithMessage(err, "invalid format for esbuild arguments")
@@ -95,6 +213,9 @@ func testServer(ctx context.Context, args *args) (*http.Server, error) {
 	buildOptions.Outbase = ""
 	buildOptions.Outdir = "dist"
 	buildOptions.Format = esbapi.FormatESModule
+	if dg != nil {
+		buildOptions.Metafile = true
+	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/test/", func(w http.ResponseWriter, r *http.Request) {
@@ -123,6 +244,23 @@ func testServer(ctx context.Context, args *args) (*http.Server, error) {
 		}
 		w.Header().Set("content-type", mime.TypeByExtension(".js"))
 		w.Write(result.OutputFiles[0].Contents)
+		if cache != nil {
+			cache.store(r.URL.Path, result.OutputFiles[0].Contents)
+		}
+		if dg != nil && result.Metafile != "" {
+			var mf struct {
+				Inputs map[string]json.RawMessage `json:"inputs"`
+			}
+			if err := json.Unmarshal([]byte(result.Metafile), &mf); err == nil {
+				sources := make([]string, 0, len(mf.Inputs))
+				for in := range mf.Inputs {
+					if abs, err := filepath.Abs(in); err == nil {
+						sources = append(sources, abs)
+					}
+				}
+				dg.set(src, sources)
+			}
+		}
 	})
 	mux.HandleFunc("/qunit.js", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("content-type", mime.TypeByExtension(".js"))
@@ -132,9 +270,55 @@ func testServer(ctx context.Context, args *args) (*http.Server, error) {
 		w.Header().Set("content-type", mime.TypeByExtension(".css"))
 		w.Write(qunitCSS)
 	})
-	addr := "127.0.0.1:0"
+	if hub != nil {
+		mux.HandleFunc("/dashboard", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("content-type", mime.TypeByExtension(".html"))
+			w.Write([]byte(dashboardHTML))
+		})
+		mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+			flusher, ok := w.(http.Flusher)
+			if !ok {
+				http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+				return
+			}
+			ch := hub.subscribe()
+			defer hub.unsubscribe(ch)
+			w.Header().Set("content-type", "text/event-stream")
+			w.Header().Set("cache-control", "no-cache")
+			w.Header().Set("connection", "keep-alive")
+			for {
+				select {
+				case <-r.Context().Done():
+					return
+				case b := <-ch:
+					fmt.Fprintf(w, "data: %s\n\n", b)
+					flusher.Flush()
+				}
+			}
+		})
+		mux.HandleFunc("/rerun", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			testPath := r.URL.Query().Get("path")
+			if testPath == "" {
+				http.Error(w, "missing path", http.StatusBadRequest)
+				return
+			}
+			go runAndBroadcast(ctx, *hostPtr, args, exp, hub, reporters, testPath)
+			w.WriteHeader(http.StatusAccepted)
+		})
+	}
+	bindHost := "127.0.0.1"
+	if args.Host != "" || args.Remote != "" {
+		// a remote/containerized Chrome can't reach loopback, so bind on all
+		// interfaces when it's the one connecting in.
+		bindHost = "0.0.0.0"
+	}
+	addr := fmt.Sprintf("%s:0", bindHost)
 	if args.Port != 0 {
-		addr = fmt.Sprintf("127.0.0.1:%d", args.Port)
+		addr = fmt.Sprintf("%s:%d", bindHost, args.Port)
 	}
 	l, err := net.Listen("tcp4", addr)
 	if err != nil {
@@ -220,9 +404,10 @@ type qunitRunEnd struct {
 }
 
 type runTestResult struct {
-	path    string
-	runEnd  qunitRunEnd
-	runtime time.Duration
+	path     string
+	runEnd   qunitRunEnd
+	runtime  time.Duration
+	coverage []*profiler.ScriptCoverage
 }
 
 func (r *runTestResult) Pass() bool {
@@ -254,7 +439,7 @@ func (r *runTestResult) WriteResult(prefix string, w io.Writer) {
 	}
 }
 
-func runTests(ctx context.Context, host string, path string) (*runTestResult, error) {
+func runTests(ctx context.Context, host string, path string, coverage bool) (*runTestResult, error) {
 	var start = time.Now()
 	finished := make(chan *runTestResult, 1)
 	tasks := chromedp.Tasks{
@@ -265,13 +450,34 @@ func runTests(ctx context.Context, host string, path string) (*runTestResult, er
 			}
 			finished <- r
 		}),
-		chromedp.Navigate(host + "/test/" + path),
 	}
+	if coverage {
+		tasks = append(tasks,
+			profiler.Enable(),
+			chromedp.ActionFunc(func(ctx context.Context) error {
+				_, err := profiler.StartPreciseCoverage().WithCallCount(true).WithDetailed(true).Do(ctx)
+				return errors.WithStack(err)
+			}),
+		)
+	}
+	tasks = append(tasks, chromedp.Navigate(host+"/test/"+path))
 	if err := chromedp.Run(ctx, tasks); err != nil {
 		return nil, errors.WithStack(err)
 	}
-	result := <-finished
+	var result *runTestResult
+	select {
+	case result = <-finished:
+	case <-ctx.Done():
+		return nil, errors.Errorf("timed out waiting for %q to report HARNESS_RUN_END: %v", path, ctx.Err())
+	}
 	result.runtime = time.Since(start)
+	if coverage {
+		cov, _, err := profiler.TakePreciseCoverage().Do(ctx)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		result.coverage = cov
+	}
 	return result, nil
 }
 
@@ -351,6 +557,1193 @@ func findTests(a *args) ([]string, error) {
 	return r.matches, r.error
 }
 
+func parseShard(s string) (n, m int, err error) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, errors.Errorf("invalid -shard %q, expected N/M", s)
+	}
+	n, errN := strconv.Atoi(parts[0])
+	m, errM := strconv.Atoi(parts[1])
+	if errN != nil || errM != nil || n < 1 || m < 1 || n > m {
+		return 0, 0, errors.Errorf("invalid -shard %q, expected N/M with 1<=N<=M", s)
+	}
+	return n, m, nil
+}
+
+func loadShardTimings(path string) (map[string]time.Duration, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+	var raw map[string]int64
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	timings := make(map[string]time.Duration, len(raw))
+	for path, ns := range raw {
+		timings[path] = time.Duration(ns)
+	}
+	return timings, nil
+}
+
+func writeShardTimings(path string, timings map[string]time.Duration) error {
+	raw := make(map[string]int64, len(timings))
+	for path, d := range timings {
+		raw[path] = int64(d)
+	}
+	b, err := json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.WriteFile(path, b, 0o644))
+}
+
+// selectShard returns the subset of tests assigned to the n-th (1-based) of
+// m shards. When a duration for every test is known it balances shards by
+// longest-processing-time greedy bin-packing; otherwise it falls back to a
+// simple round-robin over the (deterministically sorted) tests.
+func selectShard(tests []string, n, m int, timings map[string]time.Duration) []string {
+	sorted := append([]string(nil), tests...)
+	sort.Strings(sorted)
+
+	complete := timings != nil
+	for _, t := range sorted {
+		if _, ok := timings[t]; !ok {
+			complete = false
+			break
+		}
+	}
+	if !complete {
+		var shard []string
+		for i, t := range sorted {
+			if i%m == n-1 {
+				shard = append(shard, t)
+			}
+		}
+		return shard
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return timings[sorted[i]] > timings[sorted[j]]
+	})
+	totals := make([]time.Duration, m)
+	bins := make([][]string, m)
+	for _, t := range sorted {
+		min := 0
+		for i := 1; i < m; i++ {
+			if totals[i] < totals[min] {
+				min = i
+			}
+		}
+		bins[min] = append(bins[min], t)
+		totals[min] += timings[t]
+	}
+	return bins[n-1]
+}
+
+// testEvent is the JSON shape broadcast to the -watch dashboard's /events
+// subscribers and rendered as a row in its test grid.
+type testEvent struct {
+	Path    string `json:"path"`
+	Pass    bool   `json:"pass"`
+	Runtime int64  `json:"runtimeMs"`
+	Counts  struct {
+		Passed  int `json:"passed"`
+		Failed  int `json:"failed"`
+		Skipped int `json:"skipped"`
+	} `json:"counts"`
+	Tests []testEventCase `json:"tests"`
+}
+
+type testEventCase struct {
+	Name   string   `json:"name"`
+	Status string   `json:"status"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+func newTestEvent(r *runTestResult) testEvent {
+	ev := testEvent{
+		Path:    r.path,
+		Pass:    r.Pass(),
+		Runtime: r.runtime.Milliseconds(),
+	}
+	ev.Counts.Passed = r.runEnd.TestCounts.Passed
+	ev.Counts.Failed = r.runEnd.TestCounts.Failed
+	ev.Counts.Skipped = r.runEnd.TestCounts.Skipped
+	for _, t := range r.runEnd.Tests {
+		tc := testEventCase{Name: strings.Join(t.FullName, " > "), Status: t.Status}
+		for _, e := range t.Errors {
+			tc.Errors = append(tc.Errors, fmt.Sprintf("%v", e.Actual))
+		}
+		ev.Tests = append(ev.Tests, tc)
+	}
+	return ev
+}
+
+// runAndBroadcast runs path and publishes its result to hub and to
+// reporters, for both the file watcher and the dashboard's re-run button.
+// Reporters that already wrote their final output in OnRunEnd before -watch
+// started (e.g. junit, which closed its file) won't see these later results.
+func runAndBroadcast(ctx context.Context, host string, a *args, exp *expectations, hub *eventHub, reporters []Reporter, path string) {
+	result, messages, err := runOneTest(ctx, host, a, exp, path)
+	if err != nil {
+		log.Printf("expected error running test %q: %v\n", path, err)
+		return
+	}
+	for _, rep := range reporters {
+		rep.OnTestResult(result)
+	}
+	for _, msg := range messages {
+		log.Println(msg)
+	}
+	hub.broadcast(newTestEvent(result))
+}
+
+// watchDirs adds a.Root and every non-excluded subdirectory to w, since
+// fsnotify does not watch recursively on its own.
+func watchDirs(w *fsnotify.Watcher, a *args) error {
+	absRoot, err := filepath.Abs(a.Root)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return filepath.WalkDir(absRoot, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		rel := filepath.ToSlash(mustRel(absRoot, p))
+		if rel != "." {
+			if base := filepath.Base(p); base == ".git" || base == "node_modules" {
+				return fs.SkipDir
+			}
+			for _, ex := range a.Exclude {
+				if match, _ := doublestar.Match(filepath.ToSlash(ex), rel); match {
+					return fs.SkipDir
+				}
+			}
+		}
+		return errors.WithStack(w.Add(p))
+	})
+}
+
+func mustRel(base, target string) string {
+	rel, err := filepath.Rel(base, target)
+	if err != nil {
+		return target
+	}
+	return rel
+}
+
+// matchesInclude reports whether rel (root-relative, slash separated)
+// matches a.Include without matching a.Exclude.
+func matchesInclude(a *args, rel string) bool {
+	for _, ex := range a.Exclude {
+		if match, _ := doublestar.Match(filepath.ToSlash(ex), rel); match {
+			return false
+		}
+	}
+	for _, pattern := range a.Include {
+		if match, _ := doublestar.Match(filepath.ToSlash(pattern), rel); match {
+			return true
+		}
+	}
+	return false
+}
+
+// rerunAffected re-runs the tests impacted by a change to changed: changed
+// itself when it matches -include/-exclude, plus every test dg says last
+// imported it.
+func rerunAffected(ctx context.Context, a *args, exp *expectations, dg *depGraph, hub *eventHub, reporters []Reporter, host, changed string) {
+	absRoot, err := filepath.Abs(a.Root)
+	if err != nil {
+		return
+	}
+	rel := filepath.ToSlash(mustRel(absRoot, changed))
+
+	affected := map[string]struct{}{}
+	if matchesInclude(a, rel) {
+		affected[rel] = struct{}{}
+	}
+	for _, t := range dg.affected(changed) {
+		affected[t] = struct{}{}
+	}
+	if len(affected) == 0 {
+		return
+	}
+	paths := make([]string, 0, len(affected))
+	for p := range affected {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		runAndBroadcast(ctx, host, a, exp, hub, reporters, p)
+	}
+}
+
+// watchDebounce is how long watchTests waits for a burst of writes to the
+// same file (e.g. an editor's save) to settle before re-running.
+const watchDebounce = 100 * time.Millisecond
+
+// watchTests watches a.Root for changes matching -include/-exclude and
+// re-runs the tests they affect, publishing results to hub for the -watch
+// dashboard. It blocks until ctx is done.
+func watchTests(ctx context.Context, a *args, exp *expectations, dg *depGraph, hub *eventHub, reporters []Reporter, host string) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer w.Close()
+	if err := watchDirs(w, a); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stdout, "%swatching %s for changes, dashboard at %s/dashboard%s\n", colorDim, a.Root, host, colorReset)
+
+	var mu sync.Mutex
+	timers := map[string]*time.Timer{}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			log.Println(err)
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+				if ev.Op&fsnotify.Create != 0 {
+					watchDirs(w, a)
+				}
+				continue
+			}
+			changed := ev.Name
+			mu.Lock()
+			if t, ok := timers[changed]; ok {
+				t.Stop()
+			}
+			timers[changed] = time.AfterFunc(watchDebounce, func() {
+				rerunAffected(ctx, a, exp, dg, hub, reporters, host, changed)
+			})
+			mu.Unlock()
+		}
+	}
+}
+
+// runStats summarizes a completed run for Reporter.OnRunEnd.
+type runStats struct {
+	Pass       int           `json:"pass"`
+	Fail       int           `json:"fail"`
+	Unexpected int           `json:"unexpected"`
+	Elapsed    time.Duration `json:"elapsedMs"`
+}
+
+// Reporter receives test lifecycle events as -reporter output. Multiple
+// reporters run concurrently over the same results.
+type Reporter interface {
+	OnTestStart(path string)
+	OnTestResult(r *runTestResult)
+	OnRunEnd(stats runStats)
+}
+
+// reporterSpec is one -reporter entry: a name, optionally followed by
+// ":path" for reporters that write to a file instead of stdout.
+type reporterSpec struct {
+	name string
+	file string
+}
+
+func parseReporterSpecs(s string) []reporterSpec {
+	var specs []reporterSpec
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, file, _ := strings.Cut(part, ":")
+		specs = append(specs, reporterSpec{name: name, file: file})
+	}
+	return specs
+}
+
+// reporterSink opens the destination for a file-backed reporter; an empty
+// path defaults to stdout, which the returned nil io.Closer leaves open.
+func reporterSink(file string) (io.Writer, io.Closer, error) {
+	if file == "" {
+		return os.Stdout, nil, nil
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	return f, f, nil
+}
+
+// buildReporters parses -reporter into the Reporters it names, failing
+// fast (before the browser is even launched) on an unknown reporter name.
+// stripPrefixPtr is read lazily since the shared path prefix isn't known
+// until the test files are discovered. Returned closers must be closed
+// once every reporter's OnRunEnd has run.
+func buildReporters(spec string, stripPrefixPtr *string) ([]Reporter, []io.Closer, error) {
+	var reporters []Reporter
+	var closers []io.Closer
+	for _, s := range parseReporterSpecs(spec) {
+		switch s.name {
+		case "pretty":
+			reporters = append(reporters, newPrettyReporter(stripPrefixPtr, os.Stdout))
+		case "junit":
+			file := s.file
+			if file == "" {
+				file = "junit.xml"
+			}
+			reporters = append(reporters, newJUnitReporter(file))
+		case "tap":
+			w, c, err := reporterSink(s.file)
+			if err != nil {
+				return nil, nil, err
+			}
+			reporters = append(reporters, newTAPReporter(w))
+			if c != nil {
+				closers = append(closers, c)
+			}
+		case "json":
+			w, c, err := reporterSink(s.file)
+			if err != nil {
+				return nil, nil, err
+			}
+			reporters = append(reporters, newJSONReporter(w))
+			if c != nil {
+				closers = append(closers, c)
+			}
+		default:
+			for _, c := range closers {
+				c.Close()
+			}
+			return nil, nil, errors.Errorf("unknown -reporter %q", s.name)
+		}
+	}
+	return reporters, closers, nil
+}
+
+// prettyReporter is the default human-readable reporter: one line per test
+// file as it finishes, plus a final colored pass/fail summary.
+// stripPrefixPtr is resolved lazily: reporters are built before the test
+// files are discovered, but the shared path prefix to strip isn't known
+// until after discovery, so it's backfilled through the pointer.
+type prettyReporter struct {
+	stripPrefixPtr *string
+	w              io.Writer
+}
+
+func newPrettyReporter(stripPrefixPtr *string, w io.Writer) *prettyReporter {
+	return &prettyReporter{stripPrefixPtr: stripPrefixPtr, w: w}
+}
+
+func (p *prettyReporter) OnTestStart(string) {}
+
+func (p *prettyReporter) OnTestResult(r *runTestResult) {
+	r.WriteResult(*p.stripPrefixPtr, p.w)
+}
+
+func (p *prettyReporter) OnRunEnd(stats runStats) {
+	fmt.Fprintf(p.w, "%s--\n", colorDim)
+	if stats.Fail == 0 {
+		fmt.Fprintf(p.w, "%s%s✓ %d pass %s%s\n", colorBold, colorGreen, stats.Pass, stats.Elapsed, colorReset)
+	} else {
+		fmt.Fprintf(p.w, "%s%s✗ %d fail %s%s\n", colorBold, colorRed, stats.Fail, stats.Elapsed, colorReset)
+	}
+}
+
+// junitTestsuites is the JUnit XML root emitted by junitReporter, one
+// testsuite per test file with a testcase per QUnit test.
+type junitTestsuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestsuite `xml:"testsuite"`
+}
+
+type junitTestsuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      float64         `xml:"time,attr"`
+	Testcases []junitTestcase `xml:"testcase"`
+}
+
+type junitTestcase struct {
+	Name    string        `xml:"name,attr"`
+	Time    float64       `xml:"time,attr"`
+	Skipped *struct{}     `xml:"skipped,omitempty"`
+	Failure *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// junitReporter accumulates one testsuite per test file and writes them as
+// a single JUnit XML document in OnRunEnd, for ingestion by Jenkins,
+// GitLab and Buildkite.
+type junitReporter struct {
+	path string
+
+	mu     sync.Mutex
+	suites []junitTestsuite
+}
+
+func newJUnitReporter(path string) *junitReporter {
+	return &junitReporter{path: path}
+}
+
+func (j *junitReporter) OnTestStart(string) {}
+
+func (j *junitReporter) OnTestResult(r *runTestResult) {
+	suite := junitTestsuite{
+		Name:  r.path,
+		Tests: len(r.runEnd.Tests),
+		Time:  r.runtime.Seconds(),
+	}
+	for _, t := range r.runEnd.Tests {
+		tc := junitTestcase{
+			Name: strings.Join(t.FullName, " > "),
+			Time: float64(t.Runtime) / 1000,
+		}
+		switch t.Status {
+		case "skipped":
+			tc.Skipped = &struct{}{}
+			suite.Skipped++
+		case "failed":
+			var text []string
+			for _, e := range t.Errors {
+				text = append(text, fmt.Sprintf("expected: %v\nactual: %v\n%s", e.Expected, e.Actual, e.Stack))
+			}
+			tc.Failure = &junitFailure{Message: "assertion failed", Text: strings.Join(text, "\n\n")}
+			suite.Failures++
+		}
+		suite.Testcases = append(suite.Testcases, tc)
+	}
+	j.mu.Lock()
+	j.suites = append(j.suites, suite)
+	j.mu.Unlock()
+}
+
+func (j *junitReporter) OnRunEnd(runStats) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	f, err := os.Create(j.path)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer f.Close()
+	fmt.Fprint(f, xml.Header)
+	e := xml.NewEncoder(f)
+	e.Indent("", "  ")
+	if err := e.Encode(junitTestsuites{Suites: j.suites}); err != nil {
+		log.Println(err)
+	}
+}
+
+// tapReporter emits TAP version 13 (https://testanything.org), one test
+// point per QUnit test case, with a YAML diagnostic block under each
+// failing point and the plan trailing once the total is known.
+type tapReporter struct {
+	w io.Writer
+
+	mu   sync.Mutex
+	once sync.Once
+	n    int
+}
+
+func newTAPReporter(w io.Writer) *tapReporter {
+	return &tapReporter{w: w}
+}
+
+func (t *tapReporter) header() {
+	t.once.Do(func() {
+		fmt.Fprintln(t.w, "TAP version 13")
+	})
+}
+
+func (t *tapReporter) OnTestStart(string) {
+	t.header()
+}
+
+func (t *tapReporter) OnTestResult(r *runTestResult) {
+	t.header()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, tc := range r.runEnd.Tests {
+		t.n++
+		name := r.path + " > " + strings.Join(tc.FullName, " > ")
+		switch tc.Status {
+		case "passed":
+			fmt.Fprintf(t.w, "ok %d - %s\n", t.n, name)
+		case "skipped":
+			fmt.Fprintf(t.w, "ok %d - %s # SKIP\n", t.n, name)
+		default:
+			fmt.Fprintf(t.w, "not ok %d - %s\n", t.n, name)
+			fmt.Fprintln(t.w, "  ---")
+			for _, e := range tc.Errors {
+				fmt.Fprintf(t.w, "  expected: %v\n", e.Expected)
+				fmt.Fprintf(t.w, "  actual: %v\n", e.Actual)
+				if e.Stack != "" {
+					fmt.Fprintln(t.w, "  stack: |")
+					for _, line := range strings.Split(e.Stack, "\n") {
+						fmt.Fprintf(t.w, "    %s\n", line)
+					}
+				}
+			}
+			fmt.Fprintln(t.w, "  ...")
+		}
+	}
+}
+
+func (t *tapReporter) OnRunEnd(runStats) {
+	t.header()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.w, "1..%d\n", t.n)
+}
+
+// jsonCounts is the per-file pass/fail/skip breakdown in a jsonReporter
+// "result" event.
+type jsonCounts struct {
+	Passed  int `json:"passed"`
+	Failed  int `json:"failed"`
+	Skipped int `json:"skipped"`
+}
+
+// jsonEvent is one line of jsonReporter's newline-delimited output: a
+// "start" event per test, a "result" event per finished test file, and a
+// final "end" event carrying the run summary.
+type jsonEvent struct {
+	Event   string      `json:"event"`
+	Time    time.Time   `json:"time"`
+	Path    string      `json:"path,omitempty"`
+	Pass    bool        `json:"pass,omitempty"`
+	Runtime int64       `json:"runtimeMs,omitempty"`
+	Counts  *jsonCounts `json:"counts,omitempty"`
+	Stats   *runStats   `json:"stats,omitempty"`
+}
+
+// jsonReporter writes newline-delimited jsonEvents, suitable for piping
+// into other tooling.
+type jsonReporter struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+func newJSONReporter(w io.Writer) *jsonReporter {
+	return &jsonReporter{w: w}
+}
+
+func (j *jsonReporter) write(ev jsonEvent) {
+	ev.Time = time.Now()
+	b, err := json.Marshal(ev)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.w.Write(b)
+	fmt.Fprintln(j.w)
+}
+
+func (j *jsonReporter) OnTestStart(path string) {
+	j.write(jsonEvent{Event: "start", Path: path})
+}
+
+func (j *jsonReporter) OnTestResult(r *runTestResult) {
+	j.write(jsonEvent{
+		Event:   "result",
+		Path:    r.path,
+		Pass:    r.Pass(),
+		Runtime: r.runtime.Milliseconds(),
+		Counts: &jsonCounts{
+			Passed:  r.runEnd.TestCounts.Passed,
+			Failed:  r.runEnd.TestCounts.Failed,
+			Skipped: r.runEnd.TestCounts.Skipped,
+		},
+	})
+}
+
+func (j *jsonReporter) OnRunEnd(stats runStats) {
+	j.write(jsonEvent{Event: "end", Stats: &stats})
+}
+
+type expectationStatus string
+
+const (
+	statusPass    expectationStatus = "Pass"
+	statusFailure expectationStatus = "Failure"
+	statusSkip    expectationStatus = "Skip"
+	statusFlaky   expectationStatus = "Flaky"
+	statusSlow    expectationStatus = "Slow"
+)
+
+// flakyRetries is how many additional attempts a Flaky-listed test gets
+// beyond its first, each in a fresh browser tab.
+const flakyRetries = 2
+
+// slowTimeoutMultiplier scales a.Timeout for tests listed as Slow.
+const slowTimeoutMultiplier = 3
+
+// expectation maps a test file glob, optionally followed by a ">"-joined
+// QUnit full test name, to its expected status. A blank or "#"-comment line
+// is kept as a raw pass-through entry (pattern and status left zero) so
+// hand-written annotations survive -update-expectations.
+type expectation struct {
+	pattern string
+	status  expectationStatus
+	raw     string
+}
+
+type expectations struct {
+	entries []expectation
+}
+
+func loadExpectations(path string) (*expectations, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &expectations{}, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+	e := &expectations{}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			e.entries = append(e.entries, expectation{raw: line})
+			continue
+		}
+		fields := strings.Fields(trimmed)
+		if len(fields) < 2 {
+			return nil, errors.Errorf("invalid expectations line: %q", line)
+		}
+		e.entries = append(e.entries, expectation{
+			pattern: strings.Join(fields[:len(fields)-1], " "),
+			status:  expectationStatus(fields[len(fields)-1]),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return e, nil
+}
+
+func writeExpectations(path string, entries []expectation) error {
+	var b strings.Builder
+	for _, e := range entries {
+		if e.pattern == "" {
+			fmt.Fprintf(&b, "%s\n", e.raw)
+			continue
+		}
+		fmt.Fprintf(&b, "%s %s\n", e.pattern, e.status)
+	}
+	return errors.WithStack(os.WriteFile(path, []byte(b.String()), 0o644))
+}
+
+// find looks up the expectation for a test file, or for one of its QUnit
+// tests when fullName is given, matching pattern as a doublestar glob
+// against path.
+func (e *expectations) find(path string, fullName []string) (expectation, bool) {
+	name := path
+	if len(fullName) > 0 {
+		name = path + ">" + strings.Join(fullName, ">")
+	}
+	for _, ex := range e.entries {
+		if ex.pattern == "" {
+			continue
+		}
+		if match, _ := doublestar.Match(ex.pattern, name); match {
+			return ex, true
+		}
+	}
+	return expectation{}, false
+}
+
+// reconcile compares a finished run against its expectations, returning a
+// human readable message for every unexpected pass or failure.
+func reconcile(exp *expectations, r *runTestResult) []string {
+	var messages []string
+	fileStatus := statusPass
+	if e, ok := exp.find(r.path, nil); ok {
+		fileStatus = e.status
+	}
+	if fileStatus == statusFailure {
+		if r.Pass() {
+			messages = append(messages, fmt.Sprintf("%s: unexpected pass (expected Failure)", r.path))
+		}
+	} else if !r.Pass() {
+		messages = append(messages, fmt.Sprintf("%s: unexpected failure (expected %s)", r.path, fileStatus))
+	}
+	for _, t := range r.runEnd.Tests {
+		e, ok := exp.find(r.path, t.FullName)
+		if !ok {
+			continue
+		}
+		passed := t.Status == "passed"
+		full := r.path + ">" + strings.Join(t.FullName, ">")
+		if e.status == statusFailure {
+			if passed {
+				messages = append(messages, fmt.Sprintf("%s: unexpected pass (expected Failure)", full))
+			}
+		} else if !passed {
+			messages = append(messages, fmt.Sprintf("%s: unexpected failure (expected %s)", full, e.status))
+		}
+	}
+	return messages
+}
+
+// updateExpectations folds observed results into the loaded expectations:
+// Skip, Flaky and Slow classifications are preserved as-is since they
+// reflect a human decision, everything else is set to match what was
+// actually observed. Raw pass-through entries (comments, blank lines) are
+// carried over unchanged, ahead of the sorted pattern entries.
+func updateExpectations(exp *expectations, results []*runTestResult) []expectation {
+	observed := make(map[string]*runTestResult, len(results))
+	for _, r := range results {
+		observed[r.path] = r
+	}
+	var raw []expectation
+	existing := make(map[string]expectation, len(exp.entries))
+	var order []string
+	for _, e := range exp.entries {
+		if e.pattern == "" {
+			raw = append(raw, e)
+			continue
+		}
+		if _, ok := existing[e.pattern]; !ok {
+			order = append(order, e.pattern)
+		}
+		existing[e.pattern] = e
+	}
+	for path := range observed {
+		if _, ok := existing[path]; !ok {
+			order = append(order, path)
+		}
+	}
+	sort.Strings(order)
+	updated := make([]expectation, 0, len(raw)+len(order))
+	updated = append(updated, raw...)
+	for _, pattern := range order {
+		e := existing[pattern]
+		e.pattern = pattern
+		switch e.status {
+		case statusSkip, statusFlaky, statusSlow:
+			// preserve
+		default:
+			e.status = statusFailure
+			if r, ok := observed[pattern]; ok && r.Pass() {
+				e.status = statusPass
+			}
+		}
+		updated = append(updated, e)
+	}
+	return updated
+}
+
+// runOneTest runs path in a fresh chromedp tab, retrying under -expectations
+// Flaky and scaling a.Timeout under -expectations Slow, and reconciling the
+// result against exp. The returned error is a hard failure to run the test
+// at all (e.g. a browser crash), distinct from the test itself failing.
+func runOneTest(ctx context.Context, host string, a *args, exp *expectations, path string) (*runTestResult, []string, error) {
+	attempts := 1
+	timeout := a.Timeout
+	if a.Expectations != "" {
+		if e, ok := exp.find(path, nil); ok {
+			if e.status == statusFlaky {
+				attempts = 1 + flakyRetries
+			}
+			if e.status == statusSlow {
+				timeout *= slowTimeoutMultiplier
+			}
+		}
+	}
+
+	var result *runTestResult
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		testCtx, cancelTest := chromedp.NewContext(ctx)
+		runCtx := testCtx
+		cancelTimeout := func() {}
+		if timeout > 0 {
+			runCtx, cancelTimeout = context.WithTimeout(testCtx, timeout)
+		}
+		result, err = runTests(runCtx, host, path, a.Coverage)
+		cancelTimeout()
+		if !a.KeepRunning {
+			cancelTest()
+		}
+		if err == nil && result.Pass() {
+			break
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var messages []string
+	if a.Expectations != "" {
+		messages = reconcile(exp, result)
+	}
+	return result, messages, nil
+}
+
+// sourceMap is a decoded version of the "mappings" field of a source map,
+// giving, for each generated line, the sorted list of positions at which the
+// mapping to the original source changes.
+type sourceMap struct {
+	sources []string
+	lines   [][]mapSegment
+}
+
+type mapSegment struct {
+	genCol   int
+	srcIdx   int
+	origLine int
+}
+
+type sourceMapJSON struct {
+	Sources  []string `json:"sources"`
+	Mappings string   `json:"mappings"`
+}
+
+var sourceMappingURLPrefix = []byte("//# sourceMappingURL=data:application/json;base64,")
+
+func parseInlineSourceMap(js []byte) (*sourceMap, error) {
+	idx := bytes.LastIndex(js, sourceMappingURLPrefix)
+	if idx == -1 {
+		return nil, errors.New("no inline source map found")
+	}
+	b64 := bytes.TrimSpace(js[idx+len(sourceMappingURLPrefix):])
+	raw, err := base64.StdEncoding.DecodeString(string(b64))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var smj sourceMapJSON
+	if err := json.Unmarshal(raw, &smj); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return decodeSourceMap(&smj), nil
+}
+
+const base64VLQChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+var base64VLQIndex = func() [256]int {
+	var m [256]int
+	for i := range m {
+		m[i] = -1
+	}
+	for i := 0; i < len(base64VLQChars); i++ {
+		m[base64VLQChars[i]] = i
+	}
+	return m
+}()
+
+// decodeVLQ decodes a single field-group of base64 VLQ values, as used in
+// the "mappings" string of a source map.
+func decodeVLQ(s string) []int {
+	var values []int
+	shift, value := 0, 0
+	for i := 0; i < len(s); i++ {
+		digit := base64VLQIndex[s[i]]
+		cont := digit & 32
+		digit &= 31
+		value += digit << shift
+		if cont != 0 {
+			shift += 5
+			continue
+		}
+		if value&1 != 0 {
+			value = -(value >> 1)
+		} else {
+			value >>= 1
+		}
+		values = append(values, value)
+		shift, value = 0, 0
+	}
+	return values
+}
+
+func decodeSourceMap(smj *sourceMapJSON) *sourceMap {
+	sm := &sourceMap{sources: smj.Sources}
+	srcIdx, origLine, origCol := 0, 0, 0
+	for _, lineStr := range strings.Split(smj.Mappings, ";") {
+		var segs []mapSegment
+		genCol := 0
+		if lineStr != "" {
+			for _, segStr := range strings.Split(lineStr, ",") {
+				vals := decodeVLQ(segStr)
+				if len(vals) == 0 {
+					continue
+				}
+				genCol += vals[0]
+				if len(vals) >= 4 {
+					srcIdx += vals[1]
+					origLine += vals[2]
+					origCol += vals[3]
+				}
+				segs = append(segs, mapSegment{genCol: genCol, srcIdx: srcIdx, origLine: origLine})
+			}
+		}
+		sm.lines = append(sm.lines, segs)
+	}
+	return sm
+}
+
+func lineStarts(src []byte) []int {
+	starts := []int{0}
+	for i, b := range src {
+		if b == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+	return starts
+}
+
+// coverageAt returns a lookup from generated byte offset to execution count,
+// resolving overlapping V8 coverage ranges by preferring the smallest (most
+// specific, i.e. most deeply nested) range that contains the offset.
+func coverageAt(ranges []*profiler.CoverageRange) func(offset int64) (int64, bool) {
+	sorted := append([]*profiler.CoverageRange(nil), ranges...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return (sorted[i].EndOffset - sorted[i].StartOffset) < (sorted[j].EndOffset - sorted[j].StartOffset)
+	})
+	return func(offset int64) (int64, bool) {
+		for _, r := range sorted {
+			if offset >= r.StartOffset && offset < r.EndOffset {
+				return r.Count, true
+			}
+		}
+		return 0, false
+	}
+}
+
+// fileCoverage accumulates per-line and per-function hit counts for a single
+// original (pre-bundle) source file.
+type fileCoverage struct {
+	lines map[int]int64
+	funcs map[string]int64
+}
+
+// buildCoverageReport translates the V8 precise coverage gathered across all
+// test runs back into line/function coverage of the original TypeScript/JS
+// sources, using the inline source maps esbuild emits into each bundle.
+func buildCoverageReport(scripts []*profiler.ScriptCoverage, cache *bundleCache) map[string]*fileCoverage {
+	files := make(map[string]*fileCoverage)
+	fileFor := func(name string) *fileCoverage {
+		fc := files[name]
+		if fc == nil {
+			fc = &fileCoverage{lines: make(map[int]int64), funcs: make(map[string]int64)}
+			files[name] = fc
+		}
+		return fc
+	}
+	for _, sc := range scripts {
+		u, err := url.Parse(sc.URL)
+		if err != nil || !strings.HasPrefix(u.Path, "/bundle/") {
+			continue
+		}
+		content, ok := cache.load(u.Path)
+		if !ok {
+			continue
+		}
+		sm, err := parseInlineSourceMap(content)
+		if err != nil {
+			continue
+		}
+		starts := lineStarts(content)
+		for _, fn := range sc.Functions {
+			hitAt := coverageAt(fn.Ranges)
+			var fnHits int64
+			if len(fn.Ranges) > 0 {
+				fnHits = fn.Ranges[0].Count
+			}
+			fnFile, fnLine := "", 0
+			for genLine, segs := range sm.lines {
+				if genLine >= len(starts) {
+					break
+				}
+				for _, seg := range segs {
+					offset := int64(starts[genLine] + seg.genCol)
+					count, ok := hitAt(offset)
+					if !ok || seg.srcIdx < 0 || seg.srcIdx >= len(sm.sources) {
+						continue
+					}
+					file := sm.sources[seg.srcIdx]
+					line := seg.origLine + 1 // source map lines are 0-based
+					fc := fileFor(file)
+					if count > fc.lines[line] {
+						fc.lines[line] = count
+					}
+					if fnFile == "" {
+						fnFile, fnLine = file, line
+					}
+				}
+			}
+			if fnFile != "" && fn.FunctionName != "" {
+				fc := fileFor(fnFile)
+				key := fmt.Sprintf("%s:%d", fn.FunctionName, fnLine)
+				if fnHits > fc.funcs[key] {
+					fc.funcs[key] = fnHits
+				}
+			}
+		}
+	}
+	return files
+}
+
+func coverageSummary(files map[string]*fileCoverage) (pctStmts float64) {
+	var total, hit int
+	for _, fc := range files {
+		for _, c := range fc.lines {
+			total++
+			if c > 0 {
+				hit++
+			}
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(hit) / float64(total) * 100
+}
+
+func writeCoverageReport(outPath string, files map[string]*fileCoverage) error {
+	f, err := os.Create(outPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+	switch strings.ToLower(filepath.Ext(outPath)) {
+	case ".info", ".lcov":
+		return writeLCOV(f, files)
+	default:
+		return writeIstanbulJSON(f, files)
+	}
+}
+
+func writeLCOV(w io.Writer, files map[string]*fileCoverage) error {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fc := files[name]
+		fmt.Fprintf(w, "SF:%s\n", name)
+		fnNames := make([]string, 0, len(fc.funcs))
+		for name := range fc.funcs {
+			fnNames = append(fnNames, name)
+		}
+		sort.Strings(fnNames)
+		fnHit := 0
+		for _, fn := range fnNames {
+			i := strings.LastIndex(fn, ":")
+			fmt.Fprintf(w, "FN:%s,%s\n", fn[i+1:], fn[:i])
+		}
+		for _, fn := range fnNames {
+			i := strings.LastIndex(fn, ":")
+			hits := fc.funcs[fn]
+			if hits > 0 {
+				fnHit++
+			}
+			fmt.Fprintf(w, "FNDA:%d,%s\n", hits, fn[:i])
+		}
+		fmt.Fprintf(w, "FNF:%d\nFNH:%d\n", len(fnNames), fnHit)
+		lineNos := make([]int, 0, len(fc.lines))
+		for line := range fc.lines {
+			lineNos = append(lineNos, line)
+		}
+		sort.Ints(lineNos)
+		lineHit := 0
+		for _, line := range lineNos {
+			hits := fc.lines[line]
+			if hits > 0 {
+				lineHit++
+			}
+			fmt.Fprintf(w, "DA:%d,%d\n", line, hits)
+		}
+		fmt.Fprintf(w, "LF:%d\nLH:%d\n", len(lineNos), lineHit)
+		fmt.Fprintln(w, "end_of_record")
+	}
+	return nil
+}
+
+// istanbulFile is a reduced version of the Istanbul "coverage-final.json"
+// per-file shape: one synthetic statement per covered line, good enough for
+// codecov/coveralls style %stmts summaries without full AST-accurate ranges.
+type istanbulFile struct {
+	Path         string                    `json:"path"`
+	StatementMap map[string]istanbulRange  `json:"statementMap"`
+	S            map[string]int64          `json:"s"`
+	FnMap        map[string]istanbulFnDecl `json:"fnMap"`
+	F            map[string]int64          `json:"f"`
+}
+
+type istanbulLoc struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+type istanbulRange struct {
+	Start istanbulLoc `json:"start"`
+	End   istanbulLoc `json:"end"`
+}
+
+type istanbulFnDecl struct {
+	Name string        `json:"name"`
+	Loc  istanbulRange `json:"loc"`
+}
+
+func writeIstanbulJSON(w io.Writer, files map[string]*fileCoverage) error {
+	out := make(map[string]istanbulFile, len(files))
+	for name, fc := range files {
+		jf := istanbulFile{
+			Path:         name,
+			StatementMap: make(map[string]istanbulRange, len(fc.lines)),
+			S:            make(map[string]int64, len(fc.lines)),
+			FnMap:        make(map[string]istanbulFnDecl, len(fc.funcs)),
+			F:            make(map[string]int64, len(fc.funcs)),
+		}
+		i := 0
+		for line, hits := range fc.lines {
+			key := strconv.Itoa(i)
+			jf.StatementMap[key] = istanbulRange{Start: istanbulLoc{Line: line}, End: istanbulLoc{Line: line}}
+			jf.S[key] = hits
+			i++
+		}
+		i = 0
+		for fn, hits := range fc.funcs {
+			sep := strings.LastIndex(fn, ":")
+			name := fn[:sep]
+			line, _ := strconv.Atoi(fn[sep+1:])
+			key := strconv.Itoa(i)
+			jf.FnMap[key] = istanbulFnDecl{Name: name, Loc: istanbulRange{Start: istanbulLoc{Line: line}, End: istanbulLoc{Line: line}}}
+			jf.F[key] = hits
+			i++
+		}
+		out[name] = jf
+	}
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return errors.WithStack(e.Encode(out))
+}
+
 var binStart = time.Now()
 
 func run() error {
@@ -364,26 +1757,84 @@ func run() error {
 	if len(a.Include) == 0 {
 		a.Include = defaultInclude[:]
 	}
+	if a.Coverage && a.CoverageOut == "" {
+		a.CoverageOut = "coverage-final.json"
+	}
+	if a.Reporter == "" {
+		a.Reporter = "pretty"
+	}
+	if a.UpdateExpectations && a.Expectations == "" {
+		return errors.New("-update-expectations requires -expectations")
+	}
+	var shardN, shardM int
+	if a.Shard != "" {
+		var err error
+		shardN, shardM, err = parseShard(a.Shard)
+		if err != nil {
+			return err
+		}
+	}
+
+	exp := &expectations{}
+	if a.Expectations != "" {
+		var err error
+		exp, err = loadExpectations(a.Expectations)
+		if err != nil {
+			return err
+		}
+	}
+
+	// stripPrefix isn't known until the test files are discovered below, but
+	// -reporter is validated here, before the browser is launched, so a typo
+	// fails fast instead of surfacing after a slow bootstrap.
+	var stripPrefix string
+	reporters, reporterClosers, err := buildReporters(a.Reporter, &stripPrefix)
+	if err != nil {
+		return err
+	}
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
-	allocatorOptions := chromedp.DefaultExecAllocatorOptions[:]
-	if a.Visible {
-		allocatorOptions = append(allocatorOptions, HeadlessFalse)
+	if a.Remote != "" {
+		ctx, cancel = chromedp.NewRemoteAllocator(ctx, a.Remote)
+		defer cancel()
+	} else {
+		allocatorOptions := chromedp.DefaultExecAllocatorOptions[:]
+		if a.Visible {
+			allocatorOptions = append(allocatorOptions, HeadlessFalse)
+		}
+		ctx, cancel = chromedp.NewExecAllocator(ctx, allocatorOptions...)
+		defer cancel()
 	}
-	ctx, cancel = chromedp.NewExecAllocator(ctx, allocatorOptions...)
-	defer cancel()
 
 	ctx, cancel = chromedp.NewContext(ctx)
 	defer cancel()
 
-	server, err := testServer(ctx, a)
+	var cache *bundleCache
+	if a.Coverage {
+		cache = newBundleCache()
+	}
+	var dg *depGraph
+	var hub *eventHub
+	if a.Watch {
+		dg = newDepGraph()
+		hub = newEventHub()
+	}
+	var host string
+	server, err := testServer(ctx, a, cache, exp, dg, hub, reporters, &host)
 	if err != nil {
 		return err
 	}
 
-	host := "http://" + server.Addr
+	host = "http://" + server.Addr
+	if a.Host != "" {
+		_, port, err := net.SplitHostPort(server.Addr)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		host = "http://" + net.JoinHostPort(a.Host, port)
+	}
 
 	// TODO: bootstrap and glob tests in parallel
 
@@ -398,48 +1849,84 @@ func run() error {
 	if err != nil {
 		return err
 	}
+	if a.Expectations != "" {
+		var filtered []string
+		for _, path := range tests {
+			if e, ok := exp.find(path, nil); ok && e.status == statusSkip {
+				continue
+			}
+			filtered = append(filtered, path)
+		}
+		tests = filtered
+	}
 
 	if err := <-bootstrap; err != nil {
 		return errors.WithStack(err)
 	}
 
 	// strip until the top-most shared directory
-	stripPrefix := longestCommonPrefix(tests)
+	stripPrefix = longestCommonPrefix(tests)
 	if stat, err := os.Stat(filepath.Join(a.Root, stripPrefix)); err != nil || !stat.IsDir() {
 		stripPrefix = filepath.Dir(stripPrefix) + string(filepath.Separator)
 	}
 
+	var shardTimings map[string]time.Duration
+	if a.ShardTimings != "" {
+		shardTimings, err = loadShardTimings(a.ShardTimings)
+		if err != nil {
+			return err
+		}
+	}
+	if a.Shard != "" {
+		tests = selectShard(tests, shardN, shardM, shardTimings)
+	}
+
 	results := make(chan *runTestResult)
 	printer := make(chan struct{})
+	var allCoverage []*profiler.ScriptCoverage
+	var allResults []*runTestResult
 	go func() {
 		defer close(printer)
 		for r := range results {
-			r.WriteResult(stripPrefix, os.Stdout)
+			for _, rep := range reporters {
+				rep.OnTestResult(r)
+			}
+			if hub != nil {
+				hub.broadcast(newTestEvent(r))
+			}
+			if a.Coverage {
+				allCoverage = append(allCoverage, r.coverage...)
+			}
+			allResults = append(allResults, r)
 		}
 	}()
 
 	var stats struct {
-		fail int32
-		pass int32
+		fail       int32
+		pass       int32
+		unexpected int32
 	}
 
 	var wg sync.WaitGroup
 	wg.Add(len(tests))
 	for _, path := range tests {
 		path := path
-		ctx, cancel := chromedp.NewContext(ctx)
 		go func() {
 			defer wg.Done()
-			if !a.KeepRunning {
-				defer cancel()
+			for _, rep := range reporters {
+				rep.OnTestStart(path)
 			}
-			result, err := runTests(ctx, host, path)
+			result, messages, err := runOneTest(ctx, host, a, exp, path)
 			if err != nil {
 				log.Printf("expected error running test %q: %v\n", path, err)
 				return
 			}
 			atomic.AddInt32(&stats.fail, int32(result.runEnd.TestCounts.Failed))
 			atomic.AddInt32(&stats.pass, int32(result.runEnd.TestCounts.Passed))
+			for _, msg := range messages {
+				log.Println(msg)
+				atomic.AddInt32(&stats.unexpected, 1)
+			}
 			results <- result
 		}()
 	}
@@ -447,17 +1934,58 @@ func run() error {
 	close(results)
 	<-printer
 
+	if a.UpdateExpectations {
+		if err := writeExpectations(a.Expectations, updateExpectations(exp, allResults)); err != nil {
+			return err
+		}
+	}
+
+	if a.ShardTimings != "" {
+		if shardTimings == nil {
+			shardTimings = make(map[string]time.Duration, len(allResults))
+		}
+		for _, r := range allResults {
+			shardTimings[r.path] = r.runtime
+		}
+		if err := writeShardTimings(a.ShardTimings, shardTimings); err != nil {
+			return err
+		}
+	}
+
 	if a.KeepRunning {
 		fmt.Println("Keeping browser running as requested, press Ctrl-C to quit.")
 		<-ctx.Done()
 	}
 
-	fmt.Fprintf(os.Stdout, "%s--\n", colorDim)
-	if fail := atomic.LoadInt32(&stats.fail); fail == 0 {
-		pass := atomic.LoadInt32(&stats.pass)
-		fmt.Fprintf(os.Stdout, "%s%s✓ %d pass %s%s\n", colorBold, colorGreen, pass, msSince(binStart), colorReset)
-	} else {
-		fmt.Fprintf(os.Stdout, "%s%s✗ %d fail %s%s\n", colorBold, colorRed, fail, msSince(binStart), colorReset)
+	if a.Coverage {
+		files := buildCoverageReport(allCoverage, cache)
+		if err := writeCoverageReport(a.CoverageOut, files); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "%scoverage: %d files %.1f%% stmts %s%s\n", colorDim, len(files), coverageSummary(files), a.CoverageOut, colorReset)
+	}
+
+	finalStats := runStats{
+		Pass:       int(atomic.LoadInt32(&stats.pass)),
+		Fail:       int(atomic.LoadInt32(&stats.fail)),
+		Unexpected: int(atomic.LoadInt32(&stats.unexpected)),
+		Elapsed:    msSince(binStart),
+	}
+	for _, rep := range reporters {
+		rep.OnRunEnd(finalStats)
+	}
+	for _, c := range reporterClosers {
+		if err := c.Close(); err != nil {
+			return err
+		}
+	}
+
+	if a.Watch {
+		return watchTests(ctx, a, exp, dg, hub, reporters, host)
+	}
+
+	if a.Expectations != "" && !a.UpdateExpectations && finalStats.Unexpected > 0 {
+		return errors.New("unexpected test results, see above")
 	}
 	return nil
 }
@@ -469,6 +1997,74 @@ func main() {
 	}
 }
 
+// dashboardHTML is the -watch live dashboard: a single embedded page with no
+// build step, subscribing to /events over Server-Sent Events and posting to
+// /rerun to re-run a single test file.
+var dashboardHTML = `<!doctype html>
+<html>
+
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width">
+  <title>qutest dashboard</title>
+  <style>
+    body { font: 14px monospace; margin: 1em; background: #111; color: #ddd; }
+    .file { border: 1px solid #333; border-left-width: 4px; border-radius: 4px; padding: 0.5em 1em; margin-bottom: 0.5em; }
+    .file.pass { border-left-color: #2ecc71; }
+    .file.fail { border-left-color: #e74c3c; }
+    .path { font-weight: bold; }
+    .counts { color: #999; margin-left: 1em; }
+    button { margin-left: 1em; }
+    .case { margin-left: 1em; }
+    .case.failed { color: #e74c3c; }
+    .error { margin-left: 2em; color: #f39c12; }
+  </style>
+</head>
+
+<body>
+  <h1>qutest dashboard</h1>
+  <div id="files"></div>
+  <script>
+    const files = document.getElementById('files')
+    const rows = new Map()
+
+    function el(tag, className, text) {
+      const e = document.createElement(tag)
+      if (className) e.className = className
+      if (text !== undefined) e.textContent = text
+      return e
+    }
+
+    function render(ev) {
+      let row = rows.get(ev.path)
+      if (!row) {
+        row = el('div')
+        files.appendChild(row)
+        rows.set(ev.path, row)
+      }
+      row.className = 'file ' + (ev.pass ? 'pass' : 'fail')
+      row.replaceChildren(
+        el('span', 'path', ev.path),
+        el('span', 'counts', ev.counts.passed + ' passed, ' + ev.counts.failed + ' failed, ' + ev.runtimeMs + 'ms'),
+        Object.assign(el('button', '', 're-run'), {
+          onclick: () => fetch('/rerun?path=' + encodeURIComponent(ev.path), { method: 'POST' }),
+        }),
+        ...(ev.tests || []).map(t => {
+          const c = el('div', 'case ' + t.status)
+          c.appendChild(el('span', '', t.name))
+          for (const e of t.errors || []) c.appendChild(el('div', 'error', e))
+          return c
+        }),
+      )
+    }
+
+    new EventSource('/events').onmessage = e => render(JSON.parse(e.data))
+  </script>
+</body>
+
+</html>
+`
+
 var indexHTML = template.Must(template.New("index").Parse(
 	`<!doctype html>
 <html>
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daaku/ensure"
+)
+
+func testResult(path string, pass bool) *runTestResult {
+	status := "passed"
+	if !pass {
+		status = "failed"
+	}
+	r := &runTestResult{path: path, runtime: 5 * time.Millisecond}
+	r.runEnd.Status = status
+	r.runEnd.Tests = append(r.runEnd.Tests, struct {
+		Name     string   `json:"name"`
+		FullName []string `json:"fullName"`
+		Runtime  int      `json:"runtime"`
+		Status   string   `json:"status"`
+		Errors   []struct {
+			Passed   bool        `json:"passed"`
+			Actual   interface{} `json:"actual"`
+			Expected interface{} `json:"expected"`
+			Stack    string      `json:"string"`
+			Todo     bool        `json:"todo"`
+		} `json:"errors"`
+	}{
+		FullName: []string{"suite", "passes"},
+		Status:   "passed",
+		Runtime:  2,
+	})
+	if !pass {
+		r.runEnd.Tests = append(r.runEnd.Tests, struct {
+			Name     string   `json:"name"`
+			FullName []string `json:"fullName"`
+			Runtime  int      `json:"runtime"`
+			Status   string   `json:"status"`
+			Errors   []struct {
+				Passed   bool        `json:"passed"`
+				Actual   interface{} `json:"actual"`
+				Expected interface{} `json:"expected"`
+				Stack    string      `json:"string"`
+				Todo     bool        `json:"todo"`
+			} `json:"errors"`
+		}{
+			FullName: []string{"suite", "fails"},
+			Status:   "failed",
+			Runtime:  3,
+			Errors: []struct {
+				Passed   bool        `json:"passed"`
+				Actual   interface{} `json:"actual"`
+				Expected interface{} `json:"expected"`
+				Stack    string      `json:"string"`
+				Todo     bool        `json:"todo"`
+			}{
+				{Actual: "a", Expected: "b", Stack: "at x.js:1"},
+			},
+		})
+	}
+	return r
+}
+
+func TestJUnitReporter(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "junit.xml")
+	rep := newJUnitReporter(path)
+	rep.OnTestResult(testResult("pass_test.js", true))
+	rep.OnTestResult(testResult("fail_test.js", false))
+	rep.OnRunEnd(runStats{})
+
+	b, err := os.ReadFile(path)
+	ensure.Nil(t, err)
+	ensure.True(t, strings.HasPrefix(string(b), xml.Header), "file starts with the XML header")
+
+	var doc junitTestsuites
+	ensure.Nil(t, xml.Unmarshal(b, &doc))
+	ensure.DeepEqual(t, len(doc.Suites), 2)
+
+	pass := doc.Suites[0]
+	ensure.DeepEqual(t, pass.Name, "pass_test.js")
+	ensure.DeepEqual(t, pass.Failures, 0)
+
+	fail := doc.Suites[1]
+	ensure.DeepEqual(t, fail.Name, "fail_test.js")
+	ensure.DeepEqual(t, fail.Failures, 1)
+	ensure.DeepEqual(t, fail.Testcases[1].Name, "suite > fails")
+	ensure.NotNil(t, fail.Testcases[1].Failure)
+	ensure.StringContains(t, fail.Testcases[1].Failure.Text, "actual: a")
+}
+
+func TestTAPReporter(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	rep := newTAPReporter(&buf)
+	rep.OnTestResult(testResult("pass_test.js", true))
+	rep.OnTestResult(testResult("fail_test.js", false))
+	rep.OnRunEnd(runStats{})
+
+	out := buf.String()
+	lines := strings.Split(strings.TrimRight(out, "\n"), "\n")
+	ensure.DeepEqual(t, lines[0], "TAP version 13")
+	ensure.DeepEqual(t, lines[1], "ok 1 - pass_test.js > suite > passes")
+	ensure.DeepEqual(t, lines[2], "ok 2 - fail_test.js > suite > passes")
+	ensure.DeepEqual(t, lines[3], "not ok 3 - fail_test.js > suite > fails")
+	ensure.DeepEqual(t, lines[len(lines)-1], "1..3")
+	ensure.StringContains(t, out, "expected: b")
+	ensure.StringContains(t, out, "actual: a")
+}
+
+func TestJSONReporter(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	rep := newJSONReporter(&buf)
+	rep.OnTestStart("pass_test.js")
+	rep.OnTestResult(testResult("pass_test.js", true))
+	rep.OnRunEnd(runStats{Pass: 1, Fail: 0})
+
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	ensure.DeepEqual(t, len(lines), 3)
+
+	var start jsonEvent
+	ensure.Nil(t, json.Unmarshal([]byte(lines[0]), &start))
+	ensure.DeepEqual(t, start.Event, "start")
+	ensure.DeepEqual(t, start.Path, "pass_test.js")
+
+	var result jsonEvent
+	ensure.Nil(t, json.Unmarshal([]byte(lines[1]), &result))
+	ensure.DeepEqual(t, result.Event, "result")
+	ensure.True(t, result.Pass)
+	ensure.NotNil(t, result.Counts)
+
+	var end jsonEvent
+	ensure.Nil(t, json.Unmarshal([]byte(lines[2]), &end))
+	ensure.DeepEqual(t, end.Event, "end")
+	ensure.NotNil(t, end.Stats)
+	ensure.DeepEqual(t, end.Stats.Pass, 1)
+}
+
+func TestBuildReportersUnknownName(t *testing.T) {
+	t.Parallel()
+	var stripPrefix string
+	_, _, err := buildReporters("pretty,bogus", &stripPrefix)
+	ensure.NotNil(t, err)
+	ensure.StringContains(t, err.Error(), `unknown -reporter "bogus"`)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/daaku/ensure"
+)
+
+func TestParseShard(t *testing.T) {
+	t.Parallel()
+	n, m, err := parseShard("2/4")
+	ensure.Nil(t, err)
+	ensure.DeepEqual(t, n, 2)
+	ensure.DeepEqual(t, m, 4)
+}
+
+func TestParseShardInvalid(t *testing.T) {
+	t.Parallel()
+	for _, s := range []string{"", "2", "0/4", "5/4", "a/4", "2/b"} {
+		_, _, err := parseShard(s)
+		ensure.NotNil(t, err, s)
+	}
+}
+
+func TestSelectShardRoundRobin(t *testing.T) {
+	t.Parallel()
+	tests := []string{"c.js", "a.js", "b.js", "d.js"}
+	var got []string
+	for shard := 1; shard <= 2; shard++ {
+		got = append(got, selectShard(tests, shard, 2, nil)...)
+	}
+	ensure.SameElements(t, got, tests)
+	ensure.DeepEqual(t, selectShard(tests, 1, 2, nil), []string{"a.js", "c.js"})
+	ensure.DeepEqual(t, selectShard(tests, 2, 2, nil), []string{"b.js", "d.js"})
+}
+
+func TestSelectShardBinPacking(t *testing.T) {
+	t.Parallel()
+	tests := []string{"a.js", "b.js", "c.js", "d.js"}
+	timings := map[string]time.Duration{
+		"a.js": 7 * time.Second,
+		"b.js": 1 * time.Second,
+		"c.js": 1 * time.Second,
+		"d.js": 1 * time.Second,
+	}
+	shard1 := selectShard(tests, 1, 2, timings)
+	shard2 := selectShard(tests, 2, 2, timings)
+	ensure.DeepEqual(t, shard1, []string{"a.js"})
+	ensure.SameElements(t, shard2, []string{"b.js", "c.js", "d.js"})
+}
+
+func TestSelectShardIncompleteTimingsFallsBackToRoundRobin(t *testing.T) {
+	t.Parallel()
+	tests := []string{"a.js", "b.js"}
+	timings := map[string]time.Duration{"a.js": time.Second}
+	ensure.DeepEqual(t, selectShard(tests, 1, 2, timings), []string{"a.js"})
+	ensure.DeepEqual(t, selectShard(tests, 2, 2, timings), []string{"b.js"})
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/chromedp/cdproto/profiler"
+	"github.com/daaku/ensure"
+)
+
+func TestDecodeVLQ(t *testing.T) {
+	t.Parallel()
+	ensure.DeepEqual(t, decodeVLQ("A"), []int{0})
+	ensure.DeepEqual(t, decodeVLQ("C"), []int{1})
+	ensure.DeepEqual(t, decodeVLQ("D"), []int{-1})
+	ensure.DeepEqual(t, decodeVLQ("E"), []int{2})
+	ensure.DeepEqual(t, decodeVLQ("gC"), []int{32}, "continuation bit across two chars")
+	ensure.DeepEqual(t, decodeVLQ("AAAA"), []int{0, 0, 0, 0}, "one field per char when none need continuation")
+}
+
+func TestDecodeSourceMap(t *testing.T) {
+	t.Parallel()
+	// two lines, each with one segment: line 0 maps genCol 0 to source 0
+	// line 0 col 0; line 1 maps genCol 0 to source 0 line 1 col 0.
+	smj := &sourceMapJSON{
+		Sources:  []string{"a.ts"},
+		Mappings: "AAAA;AACA",
+	}
+	sm := decodeSourceMap(smj)
+	ensure.DeepEqual(t, sm.sources, []string{"a.ts"})
+	ensure.DeepEqual(t, len(sm.lines), 2)
+	ensure.DeepEqual(t, sm.lines[0], []mapSegment{{genCol: 0, srcIdx: 0, origLine: 0}})
+	ensure.DeepEqual(t, sm.lines[1], []mapSegment{{genCol: 0, srcIdx: 0, origLine: 1}})
+}
+
+func TestCoverageAtPrefersMostSpecificRange(t *testing.T) {
+	t.Parallel()
+	at := coverageAt([]*profiler.CoverageRange{
+		{StartOffset: 0, EndOffset: 100, Count: 1},
+		{StartOffset: 10, EndOffset: 20, Count: 5},
+	})
+	count, ok := at(15)
+	ensure.True(t, ok)
+	ensure.DeepEqual(t, count, int64(5), "the narrower nested range should win over the outer one")
+
+	count, ok = at(50)
+	ensure.True(t, ok)
+	ensure.DeepEqual(t, count, int64(1))
+
+	_, ok = at(200)
+	ensure.False(t, ok)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/daaku/ensure"
+)
+
+func TestDepGraphAffected(t *testing.T) {
+	t.Parallel()
+	dg := newDepGraph()
+	dg.set("a_test.js", []string{"a.js", "shared.js"})
+	dg.set("b_test.js", []string{"b.js", "shared.js"})
+
+	ensure.SameElements(t, dg.affected("shared.js"), []string{"a_test.js", "b_test.js"})
+	ensure.DeepEqual(t, dg.affected("a.js"), []string{"a_test.js"})
+	ensure.DeepEqual(t, dg.affected("unrelated.js"), []string(nil))
+}
+
+func TestDepGraphSetReplacesPriorSources(t *testing.T) {
+	t.Parallel()
+	dg := newDepGraph()
+	dg.set("a_test.js", []string{"old.js"})
+	dg.set("a_test.js", []string{"new.js"})
+
+	ensure.DeepEqual(t, dg.affected("old.js"), []string(nil))
+	ensure.DeepEqual(t, dg.affected("new.js"), []string{"a_test.js"})
+}
+
+func TestMatchesInclude(t *testing.T) {
+	t.Parallel()
+	a := &args{Include: []string{"**/*.js"}, Exclude: []string{"**/vendor/**"}}
+	ensure.True(t, matchesInclude(a, "a_test.js"))
+	ensure.True(t, matchesInclude(a, "nested/b_test.js"))
+	ensure.False(t, matchesInclude(a, "nested/vendor/c.js"), "excluded even though it matches include")
+	ensure.False(t, matchesInclude(a, "a_test.ts"), "does not match the include glob")
+}